@@ -15,128 +15,837 @@
 package patch
 
 import (
+	"bytes"
+	stdjson "encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	"emperror.dev/errors"
+	"github.com/bytedance/sonic"
 	"github.com/disaster37/k8s-objectmatcher/patch"
+	jsonpatch "github.com/evanphx/json-patch"
 	json "github.com/json-iterator/go"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
-type CalculateOption func([]byte, []byte) ([]byte, []byte, error)
+// calculateConfig carries the options collected from the CalculateOption
+// chain before the three-way patch is computed.
+type calculateConfig struct {
+	patchType      types.PatchType
+	optimisticLock bool
+}
+
+// CalculateOption mutates the calculate configuration used by
+// PatchMaker.Calculate, e.g. to pin the patch type instead of relying on
+// auto-detection.
+type CalculateOption func(*calculateConfig) error
+
+// WithPatchType forces Calculate to compute a patch of the given type
+// instead of auto-detecting it from the object's Go type.
+func WithPatchType(patchType types.PatchType) CalculateOption {
+	return func(c *calculateConfig) error {
+		c.patchType = patchType
+		return nil
+	}
+}
+
+// WithOptimisticLock pins modifiedObject's resourceVersion to
+// currentObject's before the patch is computed, and strips it from
+// originalObject; Calculate then sets that resourceVersion in the
+// generated patch (as a test operation for JSONPatchType). Applying the
+// resulting patch with
+// client.Patch then fails with a conflict if currentObject has moved on
+// server-side since it was read, instead of silently clobbering a
+// concurrent update.
+func WithOptimisticLock() CalculateOption {
+	return func(c *calculateConfig) error {
+		c.optimisticLock = true
+		return nil
+	}
+}
 
 var DefaultPatchMaker = NewPatchMaker(&patch.K8sStrategicMergePatcher{}, &patch.BaseJSONMergePatcher{})
 
 type Maker interface {
 	Calculate(currentObject, modifiedObject, originalObject any, opts ...CalculateOption) (*PatchResult, error)
+	CalculateBytes(current, modified, original []byte, opts ...CalculateOption) (*PatchResult, error)
+	// Apply applies result.Patch to current, the same object the patch
+	// was computed against (result.Current, or its equivalent re-fetched
+	// from the API server) -- not the pre-change original.
+	Apply(current []byte, result *PatchResult) ([]byte, error)
+}
+
+// JSONPatcher computes RFC 6902 JSON Patch documents, as an alternative
+// to the RFC 7396 merge documents produced by patch.JSONMergePatcher.
+type JSONPatcher interface {
+	// CreateThreeWayJSONPatch diffs original against modified to decide
+	// *what* changed, then rebases those add/remove/replace operations
+	// onto current so a path this library never touched (added or
+	// drifted on the server, absent from both original and modified) is
+	// left alone. Each rebased remove or replace op is preceded by a test
+	// operation pinning current's value at that path — not original's — so
+	// applying the patch fails if the field moved since current was
+	// read; that's a tighter, more immediate conflict check than diffing
+	// original vs. modified would give, and mirrors what a client would
+	// want right before calling client.Patch.
+	CreateThreeWayJSONPatch(original, modified, current []byte) ([]byte, error)
+}
+
+// BaseJSONPatcher is the default JSONPatcher, built directly on
+// github.com/evanphx/json-patch the same way patch.BaseJSONMergePatcher
+// is built on it for merge patches.
+type BaseJSONPatcher struct{}
+
+func (p *BaseJSONPatcher) CreateThreeWayJSONPatch(original, modified, current []byte) ([]byte, error) {
+	var originalVal, modifiedVal, currentVal any
+
+	if err := json.Unmarshal(original, &originalVal); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode original object")
+	}
+	if err := json.Unmarshal(modified, &modifiedVal); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode modified object")
+	}
+	if err := json.Unmarshal(current, &currentVal); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode current object")
+	}
+
+	ops := []jsonPatchOp{}
+	diffJSONPatchOps("", originalVal, modifiedVal, currentVal, &ops)
+
+	return json.Marshal(ops)
+}
+
+// jsonPatchOp is a single RFC 6902 operation.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// diffJSONPatchOps decides what changed between original and modified,
+// then rebases that change onto current's actual value at each path:
+//   - a key present in original but dropped from modified is only
+//     removed if current still has it
+//   - a key absent from original but added in modified is only added if
+//     current doesn't already agree with it
+//   - a leaf original and modified disagree on is tested against
+//     current's value and replaced with modified's
+//
+// Subtrees where original and modified agree are left untouched
+// entirely, even if current has drifted from original there; a
+// JSONMergePatcher three-way merge, which diffs current against
+// modified, would set such a drifted field back. Map keys are visited in
+// sorted order so the emitted ops, and therefore the serialized Patch
+// bytes, are deterministic for identical input.
+func diffJSONPatchOps(path string, original, modified, current any, ops *[]jsonPatchOp) {
+	if reflect.DeepEqual(original, modified) {
+		return
+	}
+
+	originalMap, originalIsMap := original.(map[string]any)
+	modifiedMap, modifiedIsMap := modified.(map[string]any)
+
+	if originalIsMap && modifiedIsMap {
+		currentMap, _ := current.(map[string]any)
+
+		seen := make(map[string]struct{}, len(originalMap)+len(modifiedMap))
+		keys := make([]string, 0, len(originalMap)+len(modifiedMap))
+		for k := range originalMap {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+		for k := range modifiedMap {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			childPath := path + "/" + escapeJSONPointerToken(k)
+			ov, ook := originalMap[k]
+			mv, mok := modifiedMap[k]
+			cv, cok := currentMap[k]
+
+			switch {
+			case ook && !mok:
+				// Removed between original and modified; nothing to do
+				// if current already lost it too.
+				if cok {
+					*ops = append(*ops, jsonPatchOp{Op: "test", Path: childPath, Value: cv})
+					*ops = append(*ops, jsonPatchOp{Op: "remove", Path: childPath})
+				}
+			case !ook && mok:
+				// Added between original and modified.
+				if !cok {
+					*ops = append(*ops, jsonPatchOp{Op: "add", Path: childPath, Value: mv})
+				} else if !reflect.DeepEqual(cv, mv) {
+					*ops = append(*ops, jsonPatchOp{Op: "test", Path: childPath, Value: cv})
+					*ops = append(*ops, jsonPatchOp{Op: "replace", Path: childPath, Value: mv})
+				}
+			case cok:
+				// Present on all three sides; recurse so only the
+				// leaves original and modified actually disagree on
+				// get touched.
+				diffJSONPatchOps(childPath, ov, mv, cv, ops)
+			}
+			// ook && mok && !cok: original had it, server dropped it
+			// concurrently — nothing left on current to rebase onto.
+		}
+		return
+	}
+
+	if path == "" {
+		// Root value changed wholesale; there's no parent path to
+		// address it with add/remove, so just replace it outright.
+		*ops = append(*ops, jsonPatchOp{Op: "replace", Path: "", Value: modified})
+		return
+	}
+
+	*ops = append(*ops, jsonPatchOp{Op: "test", Path: path, Value: current})
+	*ops = append(*ops, jsonPatchOp{Op: "replace", Path: path, Value: modified})
+}
+
+// escapeJSONPointerToken escapes a map key for use as an RFC 6901 JSON
+// Pointer reference token.
+func escapeJSONPointerToken(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return token
+}
+
+// JSONCodec abstracts the Marshal/Unmarshal pair PatchMaker uses to move
+// between Go values and the byte sequences Calculate diffs, so the
+// hardcoded json-iterator dependency can be swapped out: for the std
+// library when embedding this in an admission webhook and minimizing
+// dependencies matters more than speed, or for sonic when benchmarks
+// show it's worth the extra binary size.
+type JSONCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// defaultMaxDepth bounds how deeply nested an object JSONCodec.Unmarshal
+// will accept before returning an error, so a maliciously crafted input
+// (e.g. to an admission webhook) can't blow the goroutine stack the way
+// unbounded recursive descent parsers are prone to.
+const defaultMaxDepth = 10000
+
+// checkJSONDepth scans data for '{'/'[' nesting without fully parsing
+// it, so codecs can reject pathologically deep input before handing it
+// to their underlying Unmarshal.
+func checkJSONDepth(data []byte, maxDepth int) error {
+	depth := 0
+	inString := false
+	escaped := false
+
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				return errors.Errorf("JSON input exceeds max nesting depth of %d", maxDepth)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// JSONIterCodec is the default JSONCodec, backed by
+// github.com/json-iterator/go for backwards compatibility with the rest
+// of this package's history. The zero value is ready to use.
+type JSONIterCodec struct {
+	// MaxDepth overrides defaultMaxDepth when non-zero.
+	MaxDepth int
+}
+
+// jsonIterBufferPool holds the bytes.Buffer instances JSONIterCodec.Marshal
+// encodes into, so back-to-back Calculate calls don't each allocate a
+// fresh buffer for what's ultimately a short-lived intermediate.
+var jsonIterBufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+func (c *JSONIterCodec) Marshal(v any) ([]byte, error) {
+	buf := jsonIterBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer jsonIterBufferPool.Put(buf)
+
+	if err := json.ConfigCompatibleWithStandardLibrary.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	// jsoniter's Encoder appends a trailing newline; strip it so the
+	// output matches json.Marshal byte-for-byte.
+	out := bytes.TrimRight(buf.Bytes(), "\n")
+	encoded := make([]byte, len(out))
+	copy(encoded, out)
+	return encoded, nil
+}
+
+func (c *JSONIterCodec) Unmarshal(data []byte, v any) error {
+	if err := checkJSONDepth(data, c.maxDepth()); err != nil {
+		return err
+	}
+	return json.ConfigCompatibleWithStandardLibrary.Unmarshal(data, v)
+}
+
+func (c *JSONIterCodec) maxDepth() int {
+	if c.MaxDepth > 0 {
+		return c.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// StdJSONCodec is a JSONCodec backed only by encoding/json, for callers
+// that want to avoid the json-iterator dependency entirely. The zero
+// value is ready to use.
+type StdJSONCodec struct {
+	// MaxDepth overrides defaultMaxDepth when non-zero.
+	MaxDepth int
+}
+
+func (c *StdJSONCodec) Marshal(v any) ([]byte, error) {
+	return stdjson.Marshal(v)
+}
+
+func (c *StdJSONCodec) Unmarshal(data []byte, v any) error {
+	if err := checkJSONDepth(data, c.maxDepth()); err != nil {
+		return err
+	}
+	return stdjson.Unmarshal(data, v)
+}
+
+func (c *StdJSONCodec) maxDepth() int {
+	if c.MaxDepth > 0 {
+		return c.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// SonicJSONCodec is a JSONCodec backed by github.com/bytedance/sonic,
+// for benchmark-driven callers willing to trade the std-lib's platform
+// portability for sonic's JIT-compiled encoder/decoder. The zero value
+// is ready to use.
+type SonicJSONCodec struct {
+	// MaxDepth overrides defaultMaxDepth when non-zero.
+	MaxDepth int
+}
+
+func (c *SonicJSONCodec) Marshal(v any) ([]byte, error) {
+	return sonic.Marshal(v)
+}
+
+func (c *SonicJSONCodec) Unmarshal(data []byte, v any) error {
+	if err := checkJSONDepth(data, c.maxDepth()); err != nil {
+		return err
+	}
+	return sonic.Unmarshal(data, v)
+}
+
+func (c *SonicJSONCodec) maxDepth() int {
+	if c.MaxDepth > 0 {
+		return c.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// MakerOption configures a PatchMaker at construction time.
+type MakerOption func(*PatchMaker)
+
+// WithJSONCodec swaps the JSONCodec NewPatchMaker otherwise defaults to
+// JSONIterCodec.
+func WithJSONCodec(codec JSONCodec) MakerOption {
+	return func(p *PatchMaker) {
+		p.codec = codec
+	}
 }
 
 type PatchMaker struct {
 	strategicMergePatcher patch.StrategicMergePatcher
 	jsonMergePatcher      patch.JSONMergePatcher
+	jsonPatcher           JSONPatcher
+	codec                 JSONCodec
 }
 
-func NewPatchMaker(strategicMergePatcher patch.StrategicMergePatcher, jsonMergePatcher patch.JSONMergePatcher) Maker {
-	return &PatchMaker{
+func NewPatchMaker(strategicMergePatcher patch.StrategicMergePatcher, jsonMergePatcher patch.JSONMergePatcher, opts ...MakerOption) Maker {
+	p := &PatchMaker{
 		strategicMergePatcher: strategicMergePatcher,
 		jsonMergePatcher:      jsonMergePatcher,
+		jsonPatcher:           &BaseJSONPatcher{},
+		codec:                 &JSONIterCodec{},
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 func (p *PatchMaker) Calculate(currentObject, modifiedObject, originalObject any, opts ...CalculateOption) (*PatchResult, error) {
 
-	current, err := json.ConfigCompatibleWithStandardLibrary.Marshal(currentObject)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to convert current object to byte sequence")
+	cfg := &calculateConfig{}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, errors.Wrap(err, "Failed to apply calculate option")
+		}
 	}
-	currentOrg := make([]byte, len(current))
-	copy(currentOrg, current)
-
-	modified, err := json.ConfigCompatibleWithStandardLibrary.Marshal(modifiedObject)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to convert current object to byte sequence")
+	if cfg.patchType == "" {
+		cfg.patchType = detectPatchType(currentObject)
 	}
 
-	for _, opt := range opts {
-		current, modified, err = opt(current, modified)
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed to apply option function")
+	var lockedResourceVersion string
+	var err error
+	if cfg.optimisticLock {
+		currentAccessor, accErr := meta.Accessor(currentObject)
+		if accErr != nil {
+			return nil, errors.Wrap(accErr, "Failed to access current object metadata for optimistic locking")
+		}
+		lockedResourceVersion = currentAccessor.GetResourceVersion()
+
+		if modifiedObject, err = p.pinResourceVersion(modifiedObject, currentObject); err != nil {
+			return nil, errors.Wrap(err, "Failed to pin resourceVersion for optimistic locking")
+		}
+		if originalObject, err = p.clearResourceVersion(originalObject); err != nil {
+			return nil, errors.Wrap(err, "Failed to clear resourceVersion for optimistic locking")
 		}
 	}
 
-	original, err := json.ConfigCompatibleWithStandardLibrary.Marshal(originalObject)
+	current, err := p.codec.Marshal(currentObject)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to convert current object to byte sequence")
 	}
 
-	var patch []byte
-	var patchCurrent []byte
-	var patched any
+	modified, err := p.codec.Marshal(modifiedObject)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to convert modified object to byte sequence")
+	}
 
-	patch, patchCurrent, err = p.jsonMergePatch(original, modified, current, currentOrg)
+	original, err := p.codec.Marshal(originalObject)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to generate merge patch")
+		return nil, errors.Wrap(err, "Failed to convert original object to byte sequence")
+	}
+
+	var patchBytes, patchCurrent []byte
+
+	if cfg.patchType == types.StrategicMergePatchType {
+		patchBytes, patchCurrent, err = p.strategicMergePatch(original, modified, current, currentObject)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate strategic merge patch")
+		}
+	} else {
+		result, err := p.CalculateBytes(current, modified, original, WithPatchType(cfg.patchType))
+		if err != nil {
+			return nil, err
+		}
+		cfg.patchType = result.PatchType
+		patchBytes = result.Patch
+
+		if patchCurrent, err = p.codec.Marshal(result.Patched); err != nil {
+			return nil, errors.Wrap(err, "Failed to convert patched object to byte sequence")
+		}
+	}
+
+	// Merge and strategic merge patches are computed as a diff of current
+	// against modified (plus deletions from original against modified);
+	// since pinResourceVersion already made current and modified agree on
+	// resourceVersion, that diff never picks it up on its own. The JSON
+	// patch diff skips it too, because current already agrees with
+	// modified there, so JSONPatchType gets a leading test operation on
+	// metadata.resourceVersion instead.
+	if cfg.optimisticLock && cfg.patchType != types.JSONPatchType {
+		if patchBytes, err = p.injectResourceVersion(patchBytes, lockedResourceVersion); err != nil {
+			return nil, errors.Wrap(err, "Failed to inject resourceVersion into patch")
+		}
+	} else if cfg.optimisticLock {
+		var ops []jsonPatchOp
+		if err = p.codec.Unmarshal(patchBytes, &ops); err != nil {
+			return nil, errors.Wrap(err, "Failed to decode JSON patch")
+		}
+		ops = append([]jsonPatchOp{{Op: "test", Path: "/metadata/resourceVersion", Value: lockedResourceVersion}}, ops...)
+		if patchBytes, err = p.codec.Marshal(ops); err != nil {
+			return nil, errors.Wrap(err, "Failed to inject resourceVersion test into JSON patch")
+		}
 	}
 
-	patched = reflect.New(reflect.ValueOf(currentObject).Elem().Type()).Interface()
-	if err = json.Unmarshal(patchCurrent, patched); err != nil {
+	patched := reflect.New(reflect.ValueOf(currentObject).Elem().Type()).Interface()
+	if err = p.codec.Unmarshal(patchCurrent, patched); err != nil {
 		return nil, errors.Wrap(err, "Failed to create patched object")
 	}
 
 	return &PatchResult{
-		Patch:    patch,
-		Current:  current,
-		Modified: modified,
-		Original: original,
-		Patched:  patched,
+		Patch:     patchBytes,
+		PatchType: cfg.patchType,
+		Current:   current,
+		Modified:  modified,
+		Original:  original,
+		Patched:   patched,
 	}, nil
 
 }
 
-func (p *PatchMaker) jsonMergePatch(original, modified, current, currentOrg []byte) ([]byte, []byte, error) {
+// CalculateBytes is the byte-oriented core of Calculate: it skips the
+// json.Marshal passes Calculate needs for Go values, since current,
+// modified and original are already serialized (e.g. a webhook's
+// AdmissionRequest.Object.Raw, or bytes cached by a lister). Patched is
+// decoded into a generic map[string]any, since there's no static Go type
+// to reconstruct here. Strategic merge patches aren't available on this
+// path, because CreateThreeWayMergePatch needs a concrete struct to read
+// patchMergeKey/patchStrategy tags from; pass WithPatchType(types.JSONPatchType)
+// or leave the default merge patch, or call Calculate instead.
+func (p *PatchMaker) CalculateBytes(current, modified, original []byte, opts ...CalculateOption) (*PatchResult, error) {
 
-	patch, err := p.jsonMergePatcher.CreateThreeWayJSONMergePatch(original, modified, current)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to generate merge patch")
+	cfg := &calculateConfig{}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, errors.Wrap(err, "Failed to apply calculate option")
+		}
+	}
+	if cfg.optimisticLock {
+		return nil, errors.New("WithOptimisticLock needs a Go object to read resourceVersion from; use Calculate instead")
+	}
+
+	var patchBytes, patchedCurrent []byte
+	var err error
+
+	switch cfg.patchType {
+	case types.StrategicMergePatchType:
+		return nil, errors.New("Strategic merge patches need a Go object to resolve patch metadata from; use Calculate instead")
+	case types.JSONPatchType:
+		patchBytes, patchedCurrent, err = p.jsonPatch(original, modified, current)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate JSON patch")
+		}
+	default:
+		cfg.patchType = types.MergePatchType
+		patchBytes, patchedCurrent, err = p.jsonMergePatch(original, modified, current)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate merge patch")
+		}
+	}
+
+	var patched any
+	if err := p.codec.Unmarshal(patchedCurrent, &patched); err != nil {
+		return nil, errors.Wrap(err, "Failed to create patched object")
 	}
 
-	var patchedCurrent []byte
+	return &PatchResult{
+		Patch:     patchBytes,
+		PatchType: cfg.patchType,
+		Current:   current,
+		Modified:  modified,
+		Original:  original,
+		Patched:   patched,
+	}, nil
+}
 
-	// Apply the patch to the current object and create a merge patch to see if there has any effective changes been made
-	if string(patch) != "{}" {
-		// apply the patch
-		patchCurrent, err := p.jsonMergePatcher.MergePatch(current, patch)
+// Apply reproduces the object Calculate would have produced, by applying
+// result.Patch to current according to result.PatchType. Unlike
+// Calculate, it never talks to an API server: it's meant for callers
+// that already have a PatchResult in hand and want to dry-run it, diff
+// the projected object, or drive reconciliation off of it directly.
+//
+// current must be the exact object the patch was computed against --
+// result.Current, or its equivalent re-fetched from the API server --
+// not the pre-change original. result.Patch only carries the delta
+// between current and modified (and, for JSONPatchType, test
+// operations that assert current's values), so feeding Apply anything
+// else either silently produces the wrong object (merge/strategic merge)
+// or fails its test operations (JSON patch).
+func (p *PatchMaker) Apply(current []byte, result *PatchResult) ([]byte, error) {
+	switch result.PatchType {
+	case types.JSONPatchType:
+		decoded, err := jsonpatch.DecodePatch(result.Patch)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "Failed to merge generated patch to current object")
+			return nil, errors.Wrap(err, "Failed to decode JSON patch")
 		}
-		// create the patch again, but now between the current and the patched version of the current object
-		patch, err = p.jsonMergePatcher.CreateMergePatch(current, patchCurrent)
+
+		patched, err := decoded.Apply(current)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "Failed to create patch between the current and patched current object")
+			return nil, errors.Wrap(err, "Failed to apply JSON patch")
 		}
+		return patched, nil
 
-		patchedCurrent, err = p.jsonMergePatcher.MergePatch(currentOrg, patch)
+	case types.StrategicMergePatchType:
+		lookupPatchMeta, err := strategicpatch.NewPatchMetaFromStruct(result.Patched)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "Failed to apply patch")
+			return nil, errors.Wrap(err, "Failed to build strategic merge patch metadata")
 		}
-	} else {
-		patchedCurrent = currentOrg
+
+		var currentMap, patchMap map[string]any
+		if err := p.codec.Unmarshal(current, &currentMap); err != nil {
+			return nil, errors.Wrap(err, "Failed to decode current object")
+		}
+		if err := p.codec.Unmarshal(result.Patch, &patchMap); err != nil {
+			return nil, errors.Wrap(err, "Failed to decode strategic merge patch")
+		}
+
+		patchedMap, err := strategicpatch.StrategicMergeMapPatchUsingLookupPatchMeta(currentMap, patchMap, lookupPatchMeta)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to apply strategic merge patch")
+		}
+		return p.codec.Marshal(patchedMap)
+
+	default:
+		patched, err := jsonpatch.MergePatch(current, result.Patch)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to apply merge patch")
+		}
+		return patched, nil
+	}
+}
+
+// pinResourceVersion returns a deep copy of modifiedObject with its
+// metadata.resourceVersion set to currentObject's. It works for both
+// unstructured.Unstructured and typed objects, since meta.Accessor
+// reaches resourceVersion through the metav1.Object interface either way.
+func (p *PatchMaker) pinResourceVersion(modifiedObject, currentObject any) (any, error) {
+	currentAccessor, err := meta.Accessor(currentObject)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to access current object metadata")
+	}
+
+	copied, err := p.deepCopyObject(modifiedObject)
+	if err != nil {
+		return nil, err
+	}
+
+	modifiedAccessor, err := meta.Accessor(copied)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to access modified object metadata")
+	}
+	modifiedAccessor.SetResourceVersion(currentAccessor.GetResourceVersion())
+
+	return copied, nil
+}
+
+// clearResourceVersion returns a deep copy of originalObject with its
+// metadata.resourceVersion cleared, so original and modified are
+// guaranteed to disagree on it even when the object didn't actually
+// change between reads. None of the patch computations turns that into
+// a resourceVersion entry on its own: strategicMergePatch and
+// jsonMergePatch diff current against modified, and diffJSONPatchOps
+// skips an addition current already agrees with. Calculate adds
+// resourceVersion explicitly afterward, via injectResourceVersion for
+// merge documents and a leading test operation for JSONPatchType.
+func (p *PatchMaker) clearResourceVersion(originalObject any) (any, error) {
+	copied, err := p.deepCopyObject(originalObject)
+	if err != nil {
+		return nil, err
+	}
+
+	accessor, err := meta.Accessor(copied)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to access original object metadata")
 	}
-	return patch, patchedCurrent, err
+	accessor.SetResourceVersion("")
+
+	return copied, nil
+}
+
+// injectResourceVersion sets metadata.resourceVersion to resourceVersion
+// in a merge-document patch (MergePatchType or StrategicMergePatchType).
+// Both patch types are computed as a diff of current against modified,
+// and WithOptimisticLock already made those agree on resourceVersion
+// before the diff ran, so the field never appears in the computed patch
+// to begin with -- setting it here is what actually makes the lock
+// enforceable, since the API server rejects a Patch whose body disagrees
+// with the resourceVersion it currently holds.
+func (p *PatchMaker) injectResourceVersion(patchBytes []byte, resourceVersion string) ([]byte, error) {
+	var patchMap map[string]any
+	if len(patchBytes) > 0 {
+		if err := p.codec.Unmarshal(patchBytes, &patchMap); err != nil {
+			return nil, errors.Wrap(err, "Failed to decode patch")
+		}
+	}
+	if patchMap == nil {
+		patchMap = map[string]any{}
+	}
+
+	metadata, ok := patchMap["metadata"].(map[string]any)
+	if !ok {
+		metadata = map[string]any{}
+	}
+	metadata["resourceVersion"] = resourceVersion
+	patchMap["metadata"] = metadata
+
+	return p.codec.Marshal(patchMap)
+}
+
+// deepCopyObject clones obj through a marshal/unmarshal round-trip into a
+// fresh instance of its own type, the same approach Calculate already
+// uses to materialize PatchResult.Patched. It goes through the
+// configured JSONCodec like every other encode/decode in PatchMaker.
+func (p *PatchMaker) deepCopyObject(obj any) (any, error) {
+	data, err := p.codec.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal object for deep copy")
+	}
+
+	copied := reflect.New(reflect.ValueOf(obj).Elem().Type()).Interface()
+	if err := p.codec.Unmarshal(data, copied); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal object for deep copy")
+	}
+	return copied, nil
+}
+
+// detectPatchType picks the patch type Calculate should use when the
+// caller didn't pin one explicitly via WithPatchType. Known Kubernetes
+// built-in resources (anything registered in the client-go scheme) use a
+// strategic merge patch, since that's what the API server expects for
+// them; everything else, including CRDs, falls back to a JSON merge
+// patch.
+//
+// Unstructured objects are checked first and unconditionally routed to
+// a merge patch: they have no Go struct to read patchMergeKey/
+// patchStrategy tags from, but scheme.Scheme.ObjectKinds special-cases
+// runtime.Unstructured and returns no error as long as apiVersion and
+// kind are set, which is true of every real CRD instance. Consulting
+// the scheme first would misclassify those as strategic and send them
+// into strategicMergePatch, where CreateThreeWayMergePatch's field
+// lookup on the tagless Unstructured struct fails.
+func detectPatchType(currentObject any) types.PatchType {
+	if _, ok := currentObject.(runtime.Unstructured); ok {
+		return types.MergePatchType
+	}
+
+	obj, ok := currentObject.(runtime.Object)
+	if !ok {
+		return types.MergePatchType
+	}
+
+	if _, _, err := scheme.Scheme.ObjectKinds(obj); err != nil {
+		return types.MergePatchType
+	}
+
+	return types.StrategicMergePatchType
+}
+
+func (p *PatchMaker) jsonMergePatch(original, modified, current []byte) ([]byte, []byte, error) {
+
+	patch, err := p.jsonMergePatcher.CreateThreeWayJSONMergePatch(original, modified, current)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to generate merge patch")
+	}
+
+	if string(patch) == "{}" {
+		return patch, current, nil
+	}
+
+	// apply the patch
+	patchedCurrent, err := p.jsonMergePatcher.MergePatch(current, patch)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to merge generated patch to current object")
+	}
+	// create the patch again, but now between the current and the patched version of the current object,
+	// so it only carries the fields that actually changed
+	patch, err = p.jsonMergePatcher.CreateMergePatch(current, patchedCurrent)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to create patch between the current and patched current object")
+	}
+
+	return patch, patchedCurrent, nil
+}
+
+// strategicMergePatch mirrors jsonMergePatch but computes the three-way
+// patch via the injected StrategicMergePatcher, which understands the
+// patchMergeKey / patchStrategy struct tags of built-in Kubernetes types
+// instead of replacing JSON arrays wholesale. Applying the patch and
+// re-diffing it goes straight through k8s.io/apimachinery's
+// strategicpatch helpers, since StrategicMergePatcher only exposes the
+// three-way creation step.
+func (p *PatchMaker) strategicMergePatch(original, modified, current []byte, dataStruct any) ([]byte, []byte, error) {
+
+	patch, err := p.strategicMergePatcher.CreateThreeWayMergePatch(original, modified, current, dataStruct)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to generate strategic merge patch")
+	}
+
+	if string(patch) == "{}" {
+		return patch, current, nil
+	}
+
+	patchedCurrent, err := strategicpatch.StrategicMergePatch(current, patch, dataStruct)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to merge generated patch to current object")
+	}
+
+	patch, err = strategicpatch.CreateTwoWayMergePatch(current, patchedCurrent, dataStruct)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to create patch between the current and patched current object")
+	}
+
+	return patch, patchedCurrent, nil
+}
+
+// jsonPatch mirrors jsonMergePatch and strategicMergePatch, but produces
+// an RFC 6902 operations list instead of a merge document. patchedCurrent
+// is computed by decoding and replaying the generated patch through
+// jsonpatch, the same round-trip Apply uses for types.JSONPatchType.
+func (p *PatchMaker) jsonPatch(original, modified, current []byte) ([]byte, []byte, error) {
+
+	patch, err := p.jsonPatcher.CreateThreeWayJSONPatch(original, modified, current)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to generate JSON patch")
+	}
+
+	if string(patch) == "[]" {
+		return patch, current, nil
+	}
+
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to decode generated JSON patch")
+	}
+
+	patchedCurrent, err := decoded.Apply(current)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to apply JSON patch")
+	}
+	return patch, patchedCurrent, nil
 }
 
 type PatchResult struct {
-	Patch    []byte
-	Current  []byte
-	Modified []byte
-	Original []byte
-	Patched  any
+	Patch     []byte
+	PatchType types.PatchType
+	Current   []byte
+	Modified  []byte
+	Original  []byte
+	Patched   any
 }
 
 func (p *PatchResult) IsEmpty() bool {
+	if p.PatchType == types.JSONPatchType {
+		return string(p.Patch) == "[]"
+	}
 	return string(p.Patch) == "{}"
 }
 
 func (p *PatchResult) String() string {
 	return fmt.Sprintf("\nPatch: %s \nCurrent: %s\nModified: %s\nOriginal: %s\n", p.Patch, p.Current, p.Modified, p.Original)
-}
\ No newline at end of file
+}
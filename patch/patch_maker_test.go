@@ -0,0 +1,200 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/disaster37/k8s-objectmatcher/patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newPatchMaker() *PatchMaker {
+	return NewPatchMaker(&patch.K8sStrategicMergePatcher{}, &patch.BaseJSONMergePatcher{}).(*PatchMaker)
+}
+
+func newUnstructured(resourceVersion string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("example.com/v1")
+	u.SetKind("Foo")
+	u.SetName("test")
+	u.SetResourceVersion(resourceVersion)
+	return u
+}
+
+func TestDetectPatchType(t *testing.T) {
+	t.Run("registered type gets a strategic merge patch", func(t *testing.T) {
+		if got := detectPatchType(&corev1.ConfigMap{}); got != types.StrategicMergePatchType {
+			t.Fatalf("detectPatchType(*corev1.ConfigMap) = %v, want %v", got, types.StrategicMergePatchType)
+		}
+	})
+
+	t.Run("unstructured CRD instance falls back to a merge patch", func(t *testing.T) {
+		u := newUnstructured("1")
+		if got := detectPatchType(u); got != types.MergePatchType {
+			t.Fatalf("detectPatchType(unstructured) = %v, want %v", got, types.MergePatchType)
+		}
+	})
+
+	t.Run("non runtime.Object falls back to a merge patch", func(t *testing.T) {
+		if got := detectPatchType(struct{}{}); got != types.MergePatchType {
+			t.Fatalf("detectPatchType(struct{}{}) = %v, want %v", got, types.MergePatchType)
+		}
+	})
+}
+
+// assertPatchPinsResourceVersion fails the test unless patchBytes decodes
+// to a merge-document patch whose metadata.resourceVersion equals want.
+func assertPatchPinsResourceVersion(t *testing.T, patchBytes []byte, want string) {
+	t.Helper()
+
+	var patchMap map[string]any
+	if err := json.Unmarshal(patchBytes, &patchMap); err != nil {
+		t.Fatalf("Failed to decode patch %s: %v", patchBytes, err)
+	}
+
+	metadata, ok := patchMap["metadata"].(map[string]any)
+	if !ok {
+		t.Fatalf("patch has no metadata: %s", patchBytes)
+	}
+
+	got, _ := metadata["resourceVersion"].(string)
+	if got != want {
+		t.Fatalf("patch metadata.resourceVersion = %q, want %q (patch: %s)", got, want, patchBytes)
+	}
+}
+
+func TestPatchMaker_Calculate_WithOptimisticLock_InjectsResourceVersion(t *testing.T) {
+	t.Run("strategic merge patch", func(t *testing.T) {
+		p := newPatchMaker()
+
+		current := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "2"},
+			Data:       map[string]string{"a": "1"},
+		}
+		modified := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Data:       map[string]string{"a": "2"},
+		}
+		original := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "1"},
+			Data:       map[string]string{"a": "1"},
+		}
+
+		result, err := p.Calculate(current, modified, original, WithOptimisticLock())
+		if err != nil {
+			t.Fatalf("Calculate returned error: %v", err)
+		}
+
+		assertPatchPinsResourceVersion(t, result.Patch, "2")
+	})
+
+	t.Run("merge patch", func(t *testing.T) {
+		p := newPatchMaker()
+
+		current := newUnstructured("2")
+		current.Object["data"] = map[string]any{"a": "1"}
+
+		modified := newUnstructured("")
+		modified.Object["data"] = map[string]any{"a": "2"}
+
+		original := newUnstructured("1")
+		original.Object["data"] = map[string]any{"a": "1"}
+
+		result, err := p.Calculate(current, modified, original, WithOptimisticLock())
+		if err != nil {
+			t.Fatalf("Calculate returned error: %v", err)
+		}
+
+		assertPatchPinsResourceVersion(t, result.Patch, "2")
+	})
+}
+
+// mapsEqual compares two JSON documents for equality.
+func mapsEqual(t *testing.T, name string, a, b []byte) bool {
+	t.Helper()
+
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatalf("Failed to decode %s %s: %v", name, a, err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatalf("Failed to decode %s %s: %v", name, b, err)
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func TestPatchMaker_Calculate_StrategicMergePatch_RoundTrip(t *testing.T) {
+	p := newPatchMaker()
+
+	current := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Data:       map[string]string{"a": "1"},
+	}
+	modified := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Data:       map[string]string{"a": "2"},
+	}
+	original := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Data:       map[string]string{"a": "1"},
+	}
+
+	result, err := p.Calculate(current, modified, original)
+	if err != nil {
+		t.Fatalf("Calculate returned error: %v", err)
+	}
+
+	applied, err := p.Apply(result.Current, result)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	patchedBytes, err := json.Marshal(result.Patched)
+	if err != nil {
+		t.Fatalf("Failed to marshal result.Patched: %v", err)
+	}
+
+	if !mapsEqual(t, "applied object", applied, patchedBytes) {
+		t.Fatalf("Apply(result.Current, result) = %s, want %s", applied, patchedBytes)
+	}
+}
+
+func TestPatchMaker_CalculateBytes_JSONPatch_Apply_RoundTrip(t *testing.T) {
+	p := newPatchMaker()
+
+	current := []byte(`{"a":1,"b":2}`)
+	modified := []byte(`{"a":1,"b":3}`)
+	original := []byte(`{"a":1,"b":2}`)
+
+	result, err := p.CalculateBytes(current, modified, original, WithPatchType(types.JSONPatchType))
+	if err != nil {
+		t.Fatalf("CalculateBytes returned error: %v", err)
+	}
+
+	applied, err := p.Apply(result.Current, result)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	if !mapsEqual(t, "applied object", applied, modified) {
+		t.Fatalf("Apply(result.Current, result) = %s, want %s", applied, modified)
+	}
+}
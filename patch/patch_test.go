@@ -0,0 +1,147 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package patch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func decodeOps(t *testing.T, patch []byte) []jsonPatchOp {
+	t.Helper()
+
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatalf("Failed to decode JSON patch %s: %v", patch, err)
+	}
+	return ops
+}
+
+func TestBaseJSONPatcher_CreateThreeWayJSONPatch_PreservesDriftedFields(t *testing.T) {
+	patcher := &BaseJSONPatcher{}
+
+	original := []byte(`{"a":1,"b":2}`)
+	modified := []byte(`{"a":1,"b":3}`)
+	current := []byte(`{"a":1,"b":2,"c":9}`)
+
+	patch, err := patcher.CreateThreeWayJSONPatch(original, modified, current)
+	if err != nil {
+		t.Fatalf("CreateThreeWayJSONPatch returned error: %v", err)
+	}
+
+	ops := decodeOps(t, patch)
+	for _, op := range ops {
+		if op.Path == "/c" {
+			t.Fatalf("patch touches /c, which original and modified never disagreed on: %+v", ops)
+		}
+	}
+
+	var sawReplaceB bool
+	for _, op := range ops {
+		if op.Path == "/b" && op.Op == "replace" {
+			sawReplaceB = true
+			if op.Value != float64(3) {
+				t.Fatalf("expected /b replace value 3, got %v", op.Value)
+			}
+		}
+	}
+	if !sawReplaceB {
+		t.Fatalf("expected a replace op for /b, got %+v", ops)
+	}
+}
+
+func TestBaseJSONPatcher_CreateThreeWayJSONPatch_RemoveOnlyIfStillPresent(t *testing.T) {
+	patcher := &BaseJSONPatcher{}
+
+	original := []byte(`{"a":1,"b":2}`)
+	modified := []byte(`{"a":1}`)
+
+	t.Run("still present on current", func(t *testing.T) {
+		current := []byte(`{"a":1,"b":2}`)
+		ops := decodeOps(t, mustPatch(t, patcher, original, modified, current))
+
+		var sawRemoveB bool
+		for _, op := range ops {
+			if op.Path == "/b" && op.Op == "remove" {
+				sawRemoveB = true
+			}
+		}
+		if !sawRemoveB {
+			t.Fatalf("expected a remove op for /b, got %+v", ops)
+		}
+	})
+
+	t.Run("already absent from current", func(t *testing.T) {
+		current := []byte(`{"a":1}`)
+		ops := decodeOps(t, mustPatch(t, patcher, original, modified, current))
+
+		for _, op := range ops {
+			if op.Path == "/b" {
+				t.Fatalf("did not expect any op for /b, already absent from current: %+v", ops)
+			}
+		}
+	})
+}
+
+func TestBaseJSONPatcher_CreateThreeWayJSONPatch_Deterministic(t *testing.T) {
+	patcher := &BaseJSONPatcher{}
+
+	original := []byte(`{"a":1,"b":2,"c":3,"d":4,"e":5}`)
+	modified := []byte(`{"a":10,"b":20,"c":30,"d":40,"e":50}`)
+	current := []byte(`{"a":1,"b":2,"c":3,"d":4,"e":5}`)
+
+	first := mustPatch(t, patcher, original, modified, current)
+	for i := 0; i < 10; i++ {
+		next := mustPatch(t, patcher, original, modified, current)
+		if string(next) != string(first) {
+			t.Fatalf("patch output is not deterministic:\n%s\nvs\n%s", first, next)
+		}
+	}
+}
+
+func mustPatch(t *testing.T, patcher *BaseJSONPatcher, original, modified, current []byte) []byte {
+	t.Helper()
+
+	patch, err := patcher.CreateThreeWayJSONPatch(original, modified, current)
+	if err != nil {
+		t.Fatalf("CreateThreeWayJSONPatch returned error: %v", err)
+	}
+	return patch
+}
+
+func TestPatchResult_IsEmpty(t *testing.T) {
+	cases := []struct {
+		name  string
+		patch string
+		typ   types.PatchType
+		want  bool
+	}{
+		{"empty merge patch", "{}", types.MergePatchType, true},
+		{"non-empty merge patch", `{"a":1}`, types.MergePatchType, false},
+		{"empty JSON patch", "[]", types.JSONPatchType, true},
+		{"non-empty JSON patch", `[{"op":"replace","path":"/a","value":1}]`, types.JSONPatchType, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := &PatchResult{Patch: []byte(c.patch), PatchType: c.typ}
+			if got := result.IsEmpty(); got != c.want {
+				t.Errorf("IsEmpty() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}